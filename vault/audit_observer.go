@@ -0,0 +1,200 @@
+package vault
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+
+	"github.com/hashicorp/vault/logical"
+)
+
+// RouteObserver is notified by Router before and after every request it
+// dispatches to a backend. It lets pluggable audit backends (file,
+// syslog, HMAC-chained, ...) observe traffic without Route itself
+// knowing about any particular sink.
+type RouteObserver interface {
+	// PreRoute is called after a mount has been resolved but before the
+	// backend handles the request. Returning an error aborts the route
+	// with logical.ErrPermissionDenied.
+	PreRoute(info *RouteAuditInfo) error
+
+	// PostRoute is called after the backend has handled the request,
+	// whether it returned a response, an error, or both are nil because
+	// the request was canceled.
+	PostRoute(info *RouteAuditInfo)
+}
+
+// RouteAuditInfo describes a single routed request, and is passed to each
+// registered RouteObserver.
+type RouteAuditInfo struct {
+	// SaltedToken is req.ClientToken after Route has salted it with the
+	// mount's mountEntry.SaltID, so observers never see the raw token.
+	SaltedToken string
+
+	// Mount is the prefix the request was routed to.
+	Mount string
+
+	Operation logical.Operation
+	Path      string
+
+	// DataHash is a hash of req.Data, so observers can detect duplicate
+	// or changed payloads without storing the payload itself.
+	DataHash string
+
+	// RespSummary and Err are populated for PostRoute only.
+	RespSummary string
+	Err         error
+}
+
+// runPreObservers invokes PreRoute on every registered observer in
+// registration order. An observer that returns an error aborts routing;
+// one that panics is treated the same way: this hook exists to gate
+// access, so a broken observer must fail closed rather than silently
+// letting the request through.
+func (r *Router) runPreObservers(info *RouteAuditInfo) error {
+	r.l.RLock()
+	observers := r.observers
+	r.l.RUnlock()
+
+	for _, o := range observers {
+		if err := callPreRoute(o, info); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// runPostObservers invokes PostRoute on every registered observer in
+// registration order, swallowing panics so a broken observer can't
+// prevent the others from seeing the request.
+func (r *Router) runPostObservers(info *RouteAuditInfo, resp *logical.Response, err error) {
+	r.l.RLock()
+	observers := r.observers
+	r.l.RUnlock()
+
+	for _, o := range observers {
+		callPostRoute(o, info)
+	}
+}
+
+// callPreRoute invokes o.PreRoute, recovering from any panic and logging
+// it. This is a security/audit gate, so a panicking observer fails
+// closed: the recovered panic is surfaced as an error, which aborts
+// routing just as an explicit error return would.
+func callPreRoute(o RouteObserver, info *RouteAuditInfo) (err error) {
+	defer func() {
+		if rec := recover(); rec != nil {
+			log.Printf("[ERROR] vault: route observer panicked in PreRoute: %v", rec)
+			err = fmt.Errorf("route observer panicked: %v", rec)
+		}
+	}()
+	return o.PreRoute(info)
+}
+
+// callPostRoute invokes o.PostRoute, recovering from any panic and
+// logging it so a broken observer can't take down the request that
+// triggered it; PostRoute runs after the backend has already handled the
+// request, so there is nothing left to fail closed against.
+func callPostRoute(o RouteObserver, info *RouteAuditInfo) {
+	defer func() {
+		if rec := recover(); rec != nil {
+			log.Printf("[ERROR] vault: route observer panicked in PostRoute: %v", rec)
+		}
+	}()
+	o.PostRoute(info)
+}
+
+// sensitiveRedacted stands in for the value of any data key marked
+// sensitive, so low-entropy sensitive fields (e.g. a short password)
+// can't be recovered from the hash by brute force.
+const sensitiveRedacted = "__redacted__"
+
+// hashRequestData returns a stable SHA-256 hex digest of data, used so
+// observers can correlate requests without persisting raw payloads. Keys
+// present in sensitive have their value replaced with a fixed redaction
+// marker before hashing.
+func hashRequestData(data map[string]interface{}, sensitive map[string]bool) string {
+	if len(data) == 0 {
+		return ""
+	}
+	keys := make([]string, 0, len(data))
+	for k := range data {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	ordered := make([]interface{}, 0, len(keys)*2)
+	for _, k := range keys {
+		v := data[k]
+		if sensitive[k] {
+			v = sensitiveRedacted
+		}
+		ordered = append(ordered, k, v)
+	}
+	raw, err := json.Marshal(ordered)
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(raw)
+	return fmt.Sprintf("%x", sum)
+}
+
+// summarizeResponse returns a short, audit-safe description of resp.
+func summarizeResponse(resp *logical.Response) string {
+	if resp == nil {
+		return ""
+	}
+	if resp.WrapInfo != nil {
+		return "wrapped"
+	}
+	return fmt.Sprintf("data_keys=%d", len(resp.Data))
+}
+
+// HMACObserver is a RouteObserver that salts RouteAuditInfo.SaltedToken
+// with an HMAC-SHA256 keyed hash before handing it to Emit. Sensitive
+// data keys are not this observer's concern: Route already redacts any
+// key a backend marked via SpecialPaths.Sensitive when it builds
+// DataHash, via hashRequestData, before any observer ever sees the
+// request, so every RouteObserver gets that protection for free.
+type HMACObserver struct {
+	// Key is the HMAC key used to salt SaltedToken.
+	Key []byte
+
+	// Emit is called with the sanitized info for every pre- and
+	// post-route event. Callers provide their own sink (file, syslog,
+	// etc.) here.
+	Emit func(event string, info *RouteAuditInfo)
+}
+
+// PreRoute salts info and hands it to Emit with event "request".
+func (h *HMACObserver) PreRoute(info *RouteAuditInfo) error {
+	h.emit("request", info)
+	return nil
+}
+
+// PostRoute salts info and hands it to Emit with event "response".
+func (h *HMACObserver) PostRoute(info *RouteAuditInfo) {
+	h.emit("response", info)
+}
+
+func (h *HMACObserver) emit(event string, info *RouteAuditInfo) {
+	if h.Emit == nil {
+		return
+	}
+	sanitized := *info
+	sanitized.SaltedToken = h.hmac(info.SaltedToken)
+	h.Emit(event, &sanitized)
+}
+
+// hmac returns the hex-encoded HMAC-SHA256 of s using h.Key.
+func (h *HMACObserver) hmac(s string) string {
+	if s == "" {
+		return ""
+	}
+	mac := hmac.New(sha256.New, h.Key)
+	mac.Write([]byte(s))
+	return fmt.Sprintf("%x", mac.Sum(nil))
+}
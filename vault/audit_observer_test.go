@@ -0,0 +1,120 @@
+package vault
+
+import (
+	"context"
+	"testing"
+
+	"github.com/hashicorp/vault/logical"
+)
+
+// recordingObserver appends its name to a shared slice on every call, so
+// tests can assert on call ordering.
+type recordingObserver struct {
+	name  string
+	calls *[]string
+}
+
+func (o *recordingObserver) PreRoute(info *RouteAuditInfo) error {
+	*o.calls = append(*o.calls, o.name+":pre")
+	return nil
+}
+
+func (o *recordingObserver) PostRoute(info *RouteAuditInfo) {
+	*o.calls = append(*o.calls, o.name+":post")
+}
+
+// preRoutePanicObserver panics in PreRoute only, used to verify that a
+// panicking pre-hook fails the route closed, just like a returned error
+// would.
+type preRoutePanicObserver struct{}
+
+func (preRoutePanicObserver) PreRoute(info *RouteAuditInfo) error {
+	panic("boom")
+}
+
+func (preRoutePanicObserver) PostRoute(info *RouteAuditInfo) {}
+
+// postRoutePanicObserver panics in PostRoute only, used to verify that a
+// panicking post-hook doesn't fail the request or block later observers.
+type postRoutePanicObserver struct{}
+
+func (postRoutePanicObserver) PreRoute(info *RouteAuditInfo) error { return nil }
+
+func (postRoutePanicObserver) PostRoute(info *RouteAuditInfo) {
+	panic("boom")
+}
+
+func TestRouter_Observers_Ordering(t *testing.T) {
+	r := NewRouter()
+	if err := r.Mount(&noopBackend{}, "secret/", "salt", nil, nil); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	var calls []string
+	r.AddObserver(&recordingObserver{name: "first", calls: &calls})
+	r.AddObserver(&recordingObserver{name: "second", calls: &calls})
+
+	req := &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "secret/foo",
+	}
+	if _, err := r.Route(context.Background(), req); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	want := []string{"first:pre", "second:pre", "first:post", "second:post"}
+	if len(calls) != len(want) {
+		t.Fatalf("got %v, want %v", calls, want)
+	}
+	for i := range want {
+		if calls[i] != want[i] {
+			t.Fatalf("got %v, want %v", calls, want)
+		}
+	}
+}
+
+func TestRouter_Observers_PreRoutePanic_FailsClosed(t *testing.T) {
+	r := NewRouter()
+	if err := r.Mount(&noopBackend{}, "secret/", "salt", nil, nil); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	var calls []string
+	r.AddObserver(preRoutePanicObserver{})
+	r.AddObserver(&recordingObserver{name: "after", calls: &calls})
+
+	req := &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "secret/foo",
+	}
+	if _, err := r.Route(context.Background(), req); err != logical.ErrPermissionDenied {
+		t.Fatalf("expected a panicking pre-hook to deny the route, got: %v", err)
+	}
+
+	if len(calls) != 0 {
+		t.Fatalf("expected no later observer to run once a pre-hook fails, got %v", calls)
+	}
+}
+
+func TestRouter_Observers_PostRoutePanic_DoesNotBlockOthers(t *testing.T) {
+	r := NewRouter()
+	if err := r.Mount(&noopBackend{}, "secret/", "salt", nil, nil); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	var calls []string
+	r.AddObserver(postRoutePanicObserver{})
+	r.AddObserver(&recordingObserver{name: "after", calls: &calls})
+
+	req := &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "secret/foo",
+	}
+	if _, err := r.Route(context.Background(), req); err != nil {
+		t.Fatalf("a panicking post-hook should not fail the route: %v", err)
+	}
+
+	if len(calls) != 2 || calls[0] != "after:pre" || calls[1] != "after:post" {
+		t.Fatalf("expected observer after the panicking one to still run, got %v", calls)
+	}
+}
@@ -1,9 +1,11 @@
 package vault
 
 import (
+	"context"
 	"crypto/sha1"
 	"encoding/hex"
 	"fmt"
+	"log"
 	"strings"
 	"sync"
 	"time"
@@ -15,26 +17,97 @@ import (
 
 // Router is used to do prefix based routing of a request to a logical backend
 type Router struct {
-	l    sync.RWMutex
-	root *radix.Tree
+	l                 sync.RWMutex
+	root              *radix.Tree
+	protectedPathsRaw []string
+	protectedPaths    *radix.Tree
+
+	// observers are notified before and after every routed request, in
+	// registration order.
+	observers []RouteObserver
+}
+
+// AddObserver registers o to be notified before and after every request
+// Route dispatches to a backend. Observers run in registration order.
+func (r *Router) AddObserver(o RouteObserver) {
+	r.l.Lock()
+	defer r.l.Unlock()
+	r.observers = append(r.observers, o)
 }
 
 // NewRouter returns a new router
 func NewRouter() *Router {
 	r := &Router{
-		root: radix.New(),
+		root:           radix.New(),
+		protectedPaths: pathsToRadix(nil),
 	}
 	return r
 }
 
+// RegisterProtectedPath marks prefix as off-limits to routed requests.
+// Route refuses any operation whose resolved storage path matches a
+// registered prefix, regardless of which backend is mounted there. This
+// shields internal-only paths, such as the keyring or cluster info, from
+// being reached through the normal request path.
+//
+// prefix follows the same convention as logical.Paths entries: a trailing
+// "*" matches any path beneath it, while a bare path matches only that
+// exact path.
+func (r *Router) RegisterProtectedPath(prefix string) {
+	r.l.Lock()
+	defer r.l.Unlock()
+	r.protectedPathsRaw = append(r.protectedPathsRaw, prefix)
+	r.protectedPaths = pathsToRadix(r.protectedPathsRaw)
+}
+
+// IsProtected returns true if path is covered by a prefix registered with
+// RegisterProtectedPath.
+func (r *Router) IsProtected(path string) bool {
+	r.l.RLock()
+	defer r.l.RUnlock()
+	return r.isProtectedLocked(path)
+}
+
+// isProtectedLocked is IsProtected without acquiring r.l; callers must
+// already hold it for reading.
+func (r *Router) isProtectedLocked(path string) bool {
+	// Every registration that is a prefix of path is checked, not only the
+	// longest, so an exact registration nested under a "*" one can't
+	// shadow it.
+	protected := false
+	r.protectedPaths.WalkPath(path, func(match string, raw interface{}) bool {
+		prefixMatch := raw.(bool)
+		if !prefixMatch {
+			protected = match == path
+			return protected
+		}
+
+		// A "*" registration only protects match itself and whatever falls
+		// under it as a path segment, e.g. "sys/raw*" must protect
+		// "sys/raw/foo" but not an unrelated sibling like "sys/rawness".
+		protected = path == match || strings.HasSuffix(match, "/") || path[len(match)] == '/'
+		return protected
+	})
+	return protected
+}
+
 // mountEntry is used to represent a mount point
 type mountEntry struct {
-	tainted    bool
-	salt       string
-	backend    logical.Backend
-	view       *BarrierView
-	rootPaths  *radix.Tree
-	loginPaths *radix.Tree
+	tainted       bool
+	salt          string
+	backend       logical.Backend
+	view          *BarrierView
+	rootPaths     *radix.Tree
+	loginPaths    *radix.Tree
+	sensitiveKeys map[string]bool
+
+	// wrappingView is a storage area rooted outside the backend's own
+	// keyspace, used to hold wrapped-response envelopes. It must not
+	// alias view: a backend that lists its own top-level keys must never
+	// see synthetic wrapping entries mixed in with its data. It is typed
+	// as logical.Storage, rather than *BarrierView, because Router never
+	// hands it to a backend the way it does view via req.Storage.
+	wrappingView logical.Storage
 }
 
 // SaltID is used to apply a salt and hash to an ID to make sure its not reversable
@@ -44,9 +117,13 @@ func (me *mountEntry) SaltID(id string) string {
 	return hex.EncodeToString(hash[:])
 }
 
-// Mount is used to expose a logical backend at a given prefix, using a unique salt,
-// and the barrier view for that path.
-func (r *Router) Mount(backend logical.Backend, prefix, salt string, view *BarrierView) error {
+// Mount is used to expose a logical backend at a given prefix, using a
+// unique salt, the barrier view for that path, and a separate wrapping
+// view used to hold single-use wrapped-response envelopes. wrappingView
+// must be rooted outside backend's own keyspace (e.g. a sibling barrier
+// path, not a sub-path of view) so wrapped entries never show up in the
+// backend's own storage listings.
+func (r *Router) Mount(backend logical.Backend, prefix, salt string, view *BarrierView, wrappingView logical.Storage) error {
 	r.l.Lock()
 	defer r.l.Unlock()
 
@@ -63,11 +140,13 @@ func (r *Router) Mount(backend logical.Backend, prefix, salt string, view *Barri
 
 	// Create a mount entry
 	me := &mountEntry{
-		tainted:    false,
-		backend:    backend,
-		view:       view,
-		rootPaths:  pathsToRadix(paths.Root),
-		loginPaths: pathsToRadix(paths.Unauthenticated),
+		tainted:       false,
+		backend:       backend,
+		view:          view,
+		wrappingView:  wrappingView,
+		rootPaths:     pathsToRadix(paths.Root),
+		loginPaths:    pathsToRadix(paths.Unauthenticated),
+		sensitiveKeys: sliceToSet(paths.Sensitive),
 	}
 	r.root.Insert(prefix, me)
 	return nil
@@ -144,7 +223,7 @@ func (r *Router) MatchingView(path string) *BarrierView {
 }
 
 // Route is used to route a given request
-func (r *Router) Route(req *logical.Request) (*logical.Response, error) {
+func (r *Router) Route(ctx context.Context, req *logical.Request) (*logical.Response, error) {
 	// If the path doesn't contain any slashes and doesn't end in a slash,
 	// then append the slash. This lets "foo" mean "foo/" at the root level
 	// which is almost always what we want.
@@ -159,8 +238,11 @@ func (r *Router) Route(req *logical.Request) (*logical.Response, error) {
 	if !ok {
 		return nil, fmt.Errorf("no handler for route '%s'", req.Path)
 	}
-	defer metrics.MeasureSince([]string{"route", string(req.Operation),
-		strings.Replace(mount, "/", "-", -1)}, time.Now())
+	start := time.Now()
+	defer func() {
+		metrics.MeasureSince([]string{"route", string(req.Operation),
+			strings.Replace(mount, "/", "-", -1)}, start)
+	}()
 	me := raw.(*mountEntry)
 
 	// If the path is tainted, we reject any operation except for
@@ -173,6 +255,12 @@ func (r *Router) Route(req *logical.Request) (*logical.Response, error) {
 		}
 	}
 
+	// Refuse to route into any path that has been marked protected,
+	// regardless of which backend is mounted there.
+	if r.IsProtected(req.Path) {
+		return nil, logical.ErrPermissionDenied
+	}
+
 	// Determine if this path is an unauthenticated path before we modify it
 	loginPath := r.LoginPath(req.Path)
 
@@ -206,8 +294,120 @@ func (r *Router) Route(req *logical.Request) (*logical.Response, error) {
 		req.ClientToken = clientToken
 	}()
 
-	// Invoke the backend
-	return me.backend.HandleRequest(req)
+	// Notify observers before dispatch; a pre-hook error aborts routing
+	// entirely, as if the caller lacked permission.
+	info := &RouteAuditInfo{
+		SaltedToken: me.SaltID(clientToken),
+		Mount:       mount,
+		Operation:   req.Operation,
+		Path:        req.Path,
+		DataHash:    hashRequestData(req.Data, me.sensitiveKeys),
+	}
+	if err := r.runPreObservers(info); err != nil {
+		return nil, logical.ErrPermissionDenied
+	}
+
+	// Invoke the backend. Route only threads ctx through; it does not
+	// race the call on a goroutine, since doing so can't actually abort
+	// a backend that isn't itself watching ctx, and would leave it
+	// running detached while Route mutates the shared req out from under
+	// it. Cancellation is therefore cooperative: a backend only aborts
+	// in-flight work if its own storage/network calls select on ctx.Done().
+	// This means a caller disconnecting does not, by itself, interrupt a
+	// backend already in flight; it only stops the backend from doing
+	// further ctx-aware work. Forcibly tearing down a non-cooperating
+	// backend call is out of scope here and would need to be solved at
+	// the backend layer, not by Route racing it from the outside.
+	resp, err := me.backend.HandleRequest(ctx, req)
+
+	if err != nil || resp == nil || req.WrapTTL <= 0 {
+		info.RespSummary = summarizeResponse(resp)
+		info.Err = err
+		r.runPostObservers(info, resp, err)
+		return resp, err
+	}
+
+	// The caller asked for the response to be returned wrapped in a
+	// single-use token rather than directly. The backend has already
+	// done its work (it may have issued a credential or written a
+	// leased secret), so a failure past this point must not be allowed
+	// to silently swallow that side effect: log it loudly, and make a
+	// best-effort attempt to revoke whatever the backend handed back
+	// before we give up on returning it to the caller.
+	token, wrapErr := r.wrapResponse(mount, me, resp, req.WrapTTL)
+	if wrapErr != nil {
+		log.Printf("[ERROR] vault: failed to wrap response for mount %q path %q, "+
+			"attempting to revoke the unreturnable response: %v", mount, req.Path, wrapErr)
+		if resp.Secret != nil {
+			if revokeErr := r.revokeUnwrappable(ctx, me, req, resp); revokeErr != nil {
+				log.Printf("[ERROR] vault: failed to revoke unreturnable response for mount %q "+
+					"path %q; a secret/lease may now be orphaned: %v", mount, req.Path, revokeErr)
+			}
+		}
+
+		info.RespSummary = summarizeResponse(nil)
+		info.Err = wrapErr
+		r.runPostObservers(info, nil, wrapErr)
+		return nil, wrapErr
+	}
+
+	info.RespSummary = "wrapped"
+	r.runPostObservers(info, resp, nil)
+	return &logical.Response{
+		WrapInfo: &logical.WrapInfo{
+			Token:        token,
+			TTL:          req.WrapTTL,
+			CreationTime: time.Now(),
+		},
+	}, nil
+}
+
+// revokeUnwrappable makes a best-effort attempt to revoke resp.Secret
+// after it could not be handed back to the caller (because wrapping it
+// failed), so a credential or leased secret the backend already issued
+// doesn't outlive any reference to it.
+func (r *Router) revokeUnwrappable(ctx context.Context, me *mountEntry, req *logical.Request, resp *logical.Response) error {
+	revokeReq := &logical.Request{
+		Operation: logical.RevokeOperation,
+		Path:      req.Path,
+		Storage:   me.view,
+		Secret:    resp.Secret,
+	}
+	_, err := me.backend.HandleRequest(ctx, revokeReq)
+	return err
+}
+
+// legacyBackend is the logical.Backend surface used before context.Context
+// was threaded through the routing path. Backends that have not yet been
+// migrated can be mounted by wrapping them with WrapLegacyBackend so they
+// still satisfy the current logical.Backend interface.
+type legacyBackend interface {
+	SpecialPaths() *logical.Paths
+	HandleRequest(*logical.Request) (*logical.Response, error)
+}
+
+// legacyBackendShim adapts a legacyBackend to logical.Backend by
+// discarding the context on every call.
+type legacyBackendShim struct {
+	legacyBackend
+}
+
+// HandleRequest satisfies logical.Backend by forwarding to the wrapped
+// backend's context-less HandleRequest. ctx is ignored, so wrapped
+// backends gain no cancellation support until they're migrated directly.
+func (s *legacyBackendShim) HandleRequest(ctx context.Context, req *logical.Request) (*logical.Response, error) {
+	return s.legacyBackend.HandleRequest(req)
+}
+
+// WrapLegacyBackend adapts a pre-context backend so it can be mounted
+// with Router.Mount, which now requires logical.Backend's ctx-aware
+// HandleRequest. This is a migration aid only; new backends should
+// implement the ctx-aware signature directly.
+func WrapLegacyBackend(b legacyBackend) logical.Backend {
+	if already, ok := b.(logical.Backend); ok {
+		return already
+	}
+	return &legacyBackendShim{legacyBackend: b}
 }
 
 // RootPath checks if the given path requires root privileges
@@ -268,6 +468,16 @@ func (r *Router) LoginPath(path string) bool {
 	return match == remain
 }
 
+// sliceToSet converts a slice of data keys to a set for O(1) lookups,
+// used to test backend.SpecialPaths().Sensitive membership.
+func sliceToSet(keys []string) map[string]bool {
+	set := make(map[string]bool, len(keys))
+	for _, k := range keys {
+		set[k] = true
+	}
+	return set
+}
+
 // pathsToRadix converts a the mapping of special paths to a mapping
 // of special paths to radix trees.
 func pathsToRadix(paths []string) *radix.Tree {
@@ -0,0 +1,195 @@
+package vault
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/hashicorp/vault/logical"
+)
+
+// noopBackend is a minimal logical.Backend used only to exercise Router in
+// these tests.
+type noopBackend struct {
+	paths *logical.Paths
+}
+
+func (n *noopBackend) SpecialPaths() *logical.Paths {
+	return n.paths
+}
+
+func (n *noopBackend) HandleRequest(ctx context.Context, req *logical.Request) (*logical.Response, error) {
+	return &logical.Response{}, nil
+}
+
+// memStorage is a minimal in-memory logical.Storage, standing in for a
+// real *BarrierView in tests that don't need an actual barrier.
+type memStorage struct {
+	l    sync.Mutex
+	data map[string][]byte
+}
+
+func newMemStorage() *memStorage {
+	return &memStorage{data: make(map[string][]byte)}
+}
+
+func (m *memStorage) Get(key string) (*logical.StorageEntry, error) {
+	m.l.Lock()
+	defer m.l.Unlock()
+	v, ok := m.data[key]
+	if !ok {
+		return nil, nil
+	}
+	return &logical.StorageEntry{Key: key, Value: v}, nil
+}
+
+func (m *memStorage) Put(entry *logical.StorageEntry) error {
+	m.l.Lock()
+	defer m.l.Unlock()
+	m.data[entry.Key] = entry.Value
+	return nil
+}
+
+func (m *memStorage) Delete(key string) error {
+	m.l.Lock()
+	defer m.l.Unlock()
+	delete(m.data, key)
+	return nil
+}
+
+func (m *memStorage) List(prefix string) ([]string, error) {
+	m.l.Lock()
+	defer m.l.Unlock()
+	var out []string
+	for k := range m.data {
+		if strings.HasPrefix(k, prefix) {
+			out = append(out, k)
+		}
+	}
+	return out, nil
+}
+
+// ctxCapturingBackend records the context it was called with, so tests
+// can assert that Route threads the caller's ctx through unchanged.
+type ctxCapturingBackend struct {
+	got context.Context
+}
+
+func (b *ctxCapturingBackend) SpecialPaths() *logical.Paths { return nil }
+
+func (b *ctxCapturingBackend) HandleRequest(ctx context.Context, req *logical.Request) (*logical.Response, error) {
+	b.got = ctx
+	return &logical.Response{}, nil
+}
+
+func TestRouter_Route_PropagatesContext(t *testing.T) {
+	r := NewRouter()
+	backend := &ctxCapturingBackend{}
+	if err := r.Mount(backend, "secret/", "salt", nil, nil); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	type ctxKey string
+	ctx := context.WithValue(context.Background(), ctxKey("request-id"), "abc-123")
+
+	req := &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "secret/foo",
+	}
+	if _, err := r.Route(ctx, req); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	if backend.got == nil {
+		t.Fatalf("expected backend to receive a context")
+	}
+	if v := backend.got.Value(ctxKey("request-id")); v != "abc-123" {
+		t.Fatalf("expected backend to see the caller's context value, got: %v", v)
+	}
+}
+
+// legacyNoopBackend implements only the pre-ctx legacyBackend surface, to
+// exercise WrapLegacyBackend.
+type legacyNoopBackend struct{}
+
+func (legacyNoopBackend) SpecialPaths() *logical.Paths { return nil }
+
+func (legacyNoopBackend) HandleRequest(req *logical.Request) (*logical.Response, error) {
+	return &logical.Response{Data: map[string]interface{}{"path": req.Path}}, nil
+}
+
+func TestRouter_WrapLegacyBackend(t *testing.T) {
+	r := NewRouter()
+	if err := r.Mount(WrapLegacyBackend(legacyNoopBackend{}), "legacy/", "salt", nil, nil); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	req := &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "legacy/foo",
+	}
+	resp, err := r.Route(context.Background(), req)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if resp.Data["path"] != "foo" {
+		t.Fatalf("expected the legacy backend's response to come through, got: %+v", resp.Data)
+	}
+}
+
+func TestRouter_ProtectedPaths_ExactMatch(t *testing.T) {
+	r := NewRouter()
+	r.RegisterProtectedPath("sys/keyring")
+
+	if !r.IsProtected("sys/keyring") {
+		t.Fatalf("expected 'sys/keyring' to be protected")
+	}
+	if r.IsProtected("sys/keyring/backup") {
+		t.Fatalf("exact-match registration should not protect sub-paths")
+	}
+	if r.IsProtected("sys/keyringx") {
+		t.Fatalf("exact-match registration should not protect unrelated paths sharing a prefix")
+	}
+}
+
+func TestRouter_ProtectedPaths_PrefixMatch(t *testing.T) {
+	r := NewRouter()
+	r.RegisterProtectedPath("sys/raw*")
+
+	if !r.IsProtected("sys/raw") {
+		t.Fatalf("expected 'sys/raw' to be protected")
+	}
+	if !r.IsProtected("sys/raw/logical/secret/foo") {
+		t.Fatalf("expected path beneath a '*' registration to be protected")
+	}
+	if r.IsProtected("sys/rawness") {
+		t.Fatalf("prefix match should respect a path-segment boundary, not bare string prefix")
+	}
+}
+
+func TestRouter_ProtectedPaths_NestedMount(t *testing.T) {
+	r := NewRouter()
+	if err := r.Mount(&noopBackend{}, "secret/", "salt", nil, nil); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	r.RegisterProtectedPath("secret/internal*")
+
+	req := &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "secret/internal/config",
+	}
+	if _, err := r.Route(context.Background(), req); err != logical.ErrPermissionDenied {
+		t.Fatalf("expected ErrPermissionDenied, got: %v", err)
+	}
+
+	// A sibling path under the same mount, outside the protected prefix,
+	// should still route normally.
+	req = &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "secret/foo",
+	}
+	if _, err := r.Route(context.Background(), req); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+}
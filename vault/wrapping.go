@@ -0,0 +1,263 @@
+package vault
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/vault/logical"
+)
+
+// wrapCodec compresses and decompresses the serialized response stored in
+// a wrappedEnvelope. It exists so the on-disk encoding can be swapped (for
+// example to disable compression) without touching the wrapping logic.
+type wrapCodec interface {
+	Compress([]byte) ([]byte, error)
+	Decompress([]byte) ([]byte, error)
+}
+
+// gzipCodec is the default wrapCodec.
+type gzipCodec struct{}
+
+func (gzipCodec) Compress(in []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	if _, err := w.Write(in); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (gzipCodec) Decompress(in []byte) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(in))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return ioutil.ReadAll(r)
+}
+
+// defaultWrapCodec is the codec wrapResponse and Unwrap use for every
+// Router; there is no per-Router codec setting.
+var defaultWrapCodec wrapCodec = gzipCodec{}
+
+// wrappedEnvelope is the on-disk representation of a wrapped response.
+type wrappedEnvelope struct {
+	// CreationTime is when the response was wrapped.
+	CreationTime time.Time `json:"creation_time"`
+
+	// TTL is how long the wrapping token remains valid for.
+	TTL time.Duration `json:"ttl"`
+
+	// Sum is the SHA-256 of the plaintext, serialized *logical.Response,
+	// allowing Unwrap to detect tampering with the stored payload.
+	Sum [sha256.Size]byte `json:"sum"`
+
+	// Payload is the compressed, serialized *logical.Response.
+	Payload []byte `json:"payload"`
+}
+
+// expired reports whether the envelope's TTL has elapsed as of now.
+func (w *wrappedEnvelope) expired(now time.Time) bool {
+	return w.TTL > 0 && now.After(w.CreationTime.Add(w.TTL))
+}
+
+// generateWrappingToken returns a fresh, random single-use token, rooted
+// with mount so Unwrap can find the originating mount's wrappingView
+// without consulting any in-memory state: the token itself is the
+// directory. This means a restart, crash, or HA takeover never strands
+// an outstanding token, since everything needed to read it back lives in
+// durable storage plus the token the caller already holds.
+func generateWrappingToken(mount string) (string, error) {
+	if len(mount) > 255 {
+		return "", fmt.Errorf("mount prefix too long to embed in a wrapping token")
+	}
+	random := make([]byte, 24)
+	if _, err := rand.Read(random); err != nil {
+		return "", err
+	}
+
+	buf := make([]byte, 0, 1+len(mount)+len(random))
+	buf = append(buf, byte(len(mount)))
+	buf = append(buf, mount...)
+	buf = append(buf, random...)
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// mountFromWrappingToken extracts the mount prefix embedded in token by
+// generateWrappingToken.
+func mountFromWrappingToken(token string) (string, error) {
+	buf, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return "", fmt.Errorf("invalid wrapping token")
+	}
+	if len(buf) < 1 {
+		return "", fmt.Errorf("invalid wrapping token")
+	}
+	mountLen := int(buf[0])
+	if len(buf) < 1+mountLen {
+		return "", fmt.Errorf("invalid wrapping token")
+	}
+	return string(buf[1 : 1+mountLen]), nil
+}
+
+// wrapResponse serializes resp, compresses it, and stores it in the
+// mount's dedicated wrappingView under a freshly generated single-use
+// token, returning that token. The caller is expected to replace the
+// real response with one that carries only the token and TTL.
+func (r *Router) wrapResponse(mount string, me *mountEntry, resp *logical.Response, ttl time.Duration) (string, error) {
+	if me.wrappingView == nil {
+		return "", fmt.Errorf("mount %q has no wrapping view configured", mount)
+	}
+
+	plaintext, err := json.Marshal(resp)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal response for wrapping: %v", err)
+	}
+
+	compressed, err := defaultWrapCodec.Compress(plaintext)
+	if err != nil {
+		return "", fmt.Errorf("failed to compress wrapped response: %v", err)
+	}
+
+	env := &wrappedEnvelope{
+		CreationTime: time.Now(),
+		TTL:          ttl,
+		Sum:          sha256.Sum256(plaintext),
+		Payload:      compressed,
+	}
+	raw, err := json.Marshal(env)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal wrapping envelope: %v", err)
+	}
+
+	token, err := generateWrappingToken(mount)
+	if err != nil {
+		return "", fmt.Errorf("failed to generate wrapping token: %v", err)
+	}
+
+	if err := me.wrappingView.Put(&logical.StorageEntry{
+		Key:   token,
+		Value: raw,
+	}); err != nil {
+		return "", fmt.Errorf("failed to store wrapped response: %v", err)
+	}
+
+	return token, nil
+}
+
+// unwrapLock serializes Unwrap's read-then-delete of a stored envelope, so
+// two concurrent calls with the same token can't both read it.
+var unwrapLock sync.Mutex
+
+// Unwrap validates token, atomically removes its backing entry (tokens
+// are single-use), and returns the original *logical.Response that was
+// wrapped. The owning mount is derived directly from token, so Unwrap
+// needs no in-memory index of outstanding tokens. Expired entries are
+// reaped and rejected with an error.
+func (r *Router) Unwrap(token string) (*logical.Response, error) {
+	mount, err := mountFromWrappingToken(token)
+	if err != nil {
+		return nil, err
+	}
+
+	r.l.RLock()
+	raw, ok := r.root.Get(mount)
+	r.l.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("no wrapped response found for token")
+	}
+	me := raw.(*mountEntry)
+	if me.wrappingView == nil {
+		return nil, fmt.Errorf("no wrapped response found for token")
+	}
+
+	unwrapLock.Lock()
+	entry, err := me.wrappingView.Get(token)
+	if err != nil {
+		unwrapLock.Unlock()
+		return nil, fmt.Errorf("failed to read wrapped response: %v", err)
+	}
+	if entry == nil {
+		unwrapLock.Unlock()
+		return nil, fmt.Errorf("no wrapped response found for token")
+	}
+	err = me.wrappingView.Delete(token)
+	unwrapLock.Unlock()
+	if err != nil {
+		return nil, fmt.Errorf("failed to delete wrapped response: %v", err)
+	}
+
+	var env wrappedEnvelope
+	if err := json.Unmarshal(entry.Value, &env); err != nil {
+		return nil, fmt.Errorf("failed to decode wrapping envelope: %v", err)
+	}
+	if env.expired(time.Now()) {
+		return nil, fmt.Errorf("wrapped response has expired")
+	}
+
+	plaintext, err := defaultWrapCodec.Decompress(env.Payload)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress wrapped response: %v", err)
+	}
+	if sha256.Sum256(plaintext) != env.Sum {
+		return nil, fmt.Errorf("wrapped response failed integrity check")
+	}
+
+	resp := new(logical.Response)
+	if err := json.Unmarshal(plaintext, resp); err != nil {
+		return nil, fmt.Errorf("failed to decode wrapped response: %v", err)
+	}
+	return resp, nil
+}
+
+// ReapExpiredWrapped walks every mount's wrappingView and deletes any
+// envelope whose TTL has elapsed as of now. It is meant to be invoked
+// periodically (e.g. by the same scheduler that runs other periodic
+// vault maintenance) so that wrap-and-forget callers don't leave entries
+// in storage forever.
+func (r *Router) ReapExpiredWrapped(now time.Time) error {
+	r.l.RLock()
+	mounts := make([]*mountEntry, 0)
+	r.root.Walk(func(s string, v interface{}) bool {
+		mounts = append(mounts, v.(*mountEntry))
+		return false
+	})
+	r.l.RUnlock()
+
+	for _, me := range mounts {
+		if me.wrappingView == nil {
+			continue
+		}
+		tokens, err := me.wrappingView.List("")
+		if err != nil {
+			return fmt.Errorf("failed to list wrapped responses: %v", err)
+		}
+		for _, token := range tokens {
+			entry, err := me.wrappingView.Get(token)
+			if err != nil || entry == nil {
+				continue
+			}
+			var env wrappedEnvelope
+			if err := json.Unmarshal(entry.Value, &env); err != nil {
+				continue
+			}
+			if env.expired(now) {
+				if err := me.wrappingView.Delete(token); err != nil {
+					return fmt.Errorf("failed to reap expired wrapped response: %v", err)
+				}
+			}
+		}
+	}
+	return nil
+}
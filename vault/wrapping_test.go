@@ -0,0 +1,172 @@
+package vault
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/logical"
+)
+
+// echoBackend returns a response carrying whatever req.Data held, so
+// wrapping tests can assert the round-tripped payload matches.
+type echoBackend struct{}
+
+func (echoBackend) SpecialPaths() *logical.Paths { return nil }
+
+func (echoBackend) HandleRequest(ctx context.Context, req *logical.Request) (*logical.Response, error) {
+	return &logical.Response{Data: req.Data}, nil
+}
+
+func newWrappingRouter(t *testing.T) (*Router, string) {
+	t.Helper()
+	r := NewRouter()
+	mount := "secret/"
+	if err := r.Mount(echoBackend{}, mount, "salt", nil, newMemStorage()); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	return r, mount
+}
+
+func TestRouter_Wrapping_RoundTrip(t *testing.T) {
+	r, _ := newWrappingRouter(t)
+
+	req := &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "secret/foo",
+		Data:      map[string]interface{}{"value": "super-secret"},
+		WrapTTL:   time.Minute,
+	}
+	resp, err := r.Route(context.Background(), req)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if resp.WrapInfo == nil || resp.WrapInfo.Token == "" {
+		t.Fatalf("expected a wrapping token, got: %+v", resp)
+	}
+	if resp.Data != nil {
+		t.Fatalf("wrapped response should not carry the real data, got: %+v", resp.Data)
+	}
+
+	unwrapped, err := r.Unwrap(resp.WrapInfo.Token)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if unwrapped.Data["value"] != "super-secret" {
+		t.Fatalf("expected round-tripped data, got: %+v", unwrapped.Data)
+	}
+}
+
+func TestRouter_Wrapping_SingleUse(t *testing.T) {
+	r, _ := newWrappingRouter(t)
+
+	req := &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "secret/foo",
+		Data:      map[string]interface{}{"value": "one-time"},
+		WrapTTL:   time.Minute,
+	}
+	resp, err := r.Route(context.Background(), req)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	if _, err := r.Unwrap(resp.WrapInfo.Token); err != nil {
+		t.Fatalf("first unwrap should succeed: %v", err)
+	}
+	if _, err := r.Unwrap(resp.WrapInfo.Token); err == nil {
+		t.Fatalf("expected second unwrap of the same token to fail")
+	}
+}
+
+func TestRouter_Wrapping_Expired(t *testing.T) {
+	r, _ := newWrappingRouter(t)
+
+	req := &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "secret/foo",
+		Data:      map[string]interface{}{"value": "stale"},
+		WrapTTL:   time.Nanosecond,
+	}
+	resp, err := r.Route(context.Background(), req)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	time.Sleep(time.Millisecond)
+	if _, err := r.Unwrap(resp.WrapInfo.Token); err == nil {
+		t.Fatalf("expected an expired token to be rejected")
+	}
+}
+
+func TestRouter_Wrapping_TamperDetected(t *testing.T) {
+	r, mount := newWrappingRouter(t)
+
+	req := &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "secret/foo",
+		Data:      map[string]interface{}{"value": "integrity-check"},
+		WrapTTL:   time.Minute,
+	}
+	resp, err := r.Route(context.Background(), req)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	raw, ok := r.root.Get(mount)
+	if !ok {
+		t.Fatalf("expected mount to be registered")
+	}
+	me := raw.(*mountEntry)
+	entry, err := me.wrappingView.Get(resp.WrapInfo.Token)
+	if err != nil || entry == nil {
+		t.Fatalf("expected to find the stored envelope: %v", err)
+	}
+	var env wrappedEnvelope
+	if err := json.Unmarshal(entry.Value, &env); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	env.Payload[len(env.Payload)-1] ^= 0xFF
+	tampered, err := json.Marshal(&env)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if err := me.wrappingView.Put(&logical.StorageEntry{Key: resp.WrapInfo.Token, Value: tampered}); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	if _, err := r.Unwrap(resp.WrapInfo.Token); err == nil {
+		t.Fatalf("expected a tampered envelope to be rejected")
+	}
+}
+
+func TestRouter_Wrapping_Reap(t *testing.T) {
+	r, _ := newWrappingRouter(t)
+
+	req := &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "secret/foo",
+		Data:      map[string]interface{}{"value": "wrap-and-forget"},
+		WrapTTL:   time.Nanosecond,
+	}
+	resp, err := r.Route(context.Background(), req)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	if err := r.ReapExpiredWrapped(time.Now().Add(time.Hour)); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	mount := "secret/"
+	raw, _ := r.root.Get(mount)
+	me := raw.(*mountEntry)
+	entry, err := me.wrappingView.Get(resp.WrapInfo.Token)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if entry != nil {
+		t.Fatalf("expected the reaper to have deleted the expired envelope")
+	}
+}